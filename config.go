@@ -3,6 +3,7 @@ package traefik_throttle
 import (
 	"fmt"
 	"gopkg.in/yaml.v2"
+	"log/slog"
 	"os"
 	"time"
 )
@@ -20,18 +21,47 @@ type Config struct {
 	UserRetryDelay          string                        `json:"userRetryDelay" yaml:"userRetryDelay"`
 	userRetryDelayDuration  time.Duration                 `json:"-" yaml:"-"`
 	JWTSecret               string                        `json:"jwtSecret" yaml:"jwtSecret"`
+	RetryAfterFormat        string                        `json:"retryAfterFormat" yaml:"retryAfterFormat"` // "seconds" (default) or "http-date"
+	MaxRetryAfter           string                        `json:"maxRetryAfter" yaml:"maxRetryAfter"`
+	maxRetryAfterDuration   time.Duration                 `json:"-" yaml:"-"`
+	Algorithm               string                        `json:"algorithm" yaml:"algorithm"` // "counter" (default, legacy) or "gcra"
+	Store                   string                        `json:"store" yaml:"store"`         // "memory" (default) or "redis"
+	RedisAddr               string                        `json:"redisAddr" yaml:"redisAddr"`
+	RedisPassword           string                        `json:"redisPassword" yaml:"redisPassword"`
+	RedisDB                 int                           `json:"redisDB" yaml:"redisDB"`
+	KeyPrefix               string                        `json:"keyPrefix" yaml:"keyPrefix"`
+	IdentitySources         []string                      `json:"identitySources" yaml:"identitySources"` // tried in order, e.g. "jwt:sub", "header:X-API-Key", "ip"
+	IPStrategy              string                        `json:"ipStrategy" yaml:"ipStrategy"`           // "remote" (default), "x-real-ip", "x-forwarded-for:depth=N", or a trusted-CIDR list
+	JWKSURL                 string                        `json:"jwksURL" yaml:"jwksURL"`                 // enables RS256 verification when set
+	ReloadDebounce          string                        `json:"reloadDebounce" yaml:"reloadDebounce"`   // coalesces rapid edits of EndpointsConfigLocation
+	reloadDebounceDuration  time.Duration                 `json:"-" yaml:"-"`
+	MetricsPath             string                        `json:"metricsPath" yaml:"metricsPath"`               // admin path serving Prometheus metrics; empty disables it
+	Logger                  slog.Handler                  `json:"-" yaml:"-"`                                   // structured log sink; nil keeps the default stdout logging
+	UserStateCacheSize      int                           `json:"userStateCacheSize" yaml:"userStateCacheSize"` // caps tracked per-user states; 0 uses DefaultUserStateCacheSize
+	UserStateTTL            string                        `json:"userStateTTL" yaml:"userStateTTL"`             // per-user state idle past this long is evicted
+	userStateTTLDuration    time.Duration                 `json:"-" yaml:"-"`
 }
 
 // CreateConfig initializes a default configuration for rate limiting.
 func CreateConfig() *Config {
 	return &Config{
-		MaxRequests:     10,
-		MaxQueue:        0,
-		RetryCount:      3,
-		RetryDelay:      "200ms",
-		Endpoints:       make(map[string]map[string]*Config),
-		UserMaxRequests: 1,
-		UserRetryDelay:  "1s",
+		MaxRequests:        10,
+		MaxQueue:           0,
+		RetryCount:         3,
+		RetryDelay:         "200ms",
+		Endpoints:          make(map[string]map[string]*Config),
+		UserMaxRequests:    1,
+		UserRetryDelay:     "1s",
+		RetryAfterFormat:   "seconds",
+		MaxRetryAfter:      "30s",
+		Algorithm:          AlgorithmCounter,
+		Store:              StoreMemory,
+		KeyPrefix:          "throttle:",
+		IdentitySources:    []string{"jwt:sub"},
+		IPStrategy:         "remote",
+		ReloadDebounce:     "1s",
+		UserStateCacheSize: DefaultUserStateCacheSize,
+		UserStateTTL:       "10m",
 	}
 }
 
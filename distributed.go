@@ -0,0 +1,160 @@
+package traefik_throttle
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/iolabs-ag/traefik-throttle/metrics"
+)
+
+// distributedCASRetries bounds the CAS retry loop used by distributedGCRAAllow
+// so a pathologically contended key can't spin forever.
+const distributedCASRetries = 10
+
+// applyRateLimitingDistributed admits or rejects a request against t.store
+// instead of the in-process limits map, so every Throttle instance sharing
+// the same store enforces one combined budget. There is no local queue here:
+// GCRA and the fixed-window counter both resolve a request in a single
+// store round trip, and a rejection already carries the exact retry delay.
+func (t *Throttle) applyRateLimitingDistributed(rw http.ResponseWriter, req *http.Request, state *endpointState, key string) {
+	endpoint, method := req.URL.Path, req.Method
+	start := time.Now()
+
+	allowed, retryAfter, err := t.admitDistributed(key, state.algorithm, state.retryDelay, state.maxRequests)
+	if err != nil {
+		log(LogLevelWarning, "distributed rate limit check failed, admitting request", err)
+		t.metrics.RequestsTotal.WithLabelValues(endpoint, method, metrics.OutcomeAdmitted).Inc()
+		t.metrics.WaitSeconds.Observe(time.Since(start).Seconds())
+		t.next.ServeHTTP(rw, req)
+		return
+	}
+
+	if !allowed {
+		log(LogLevelDebug, "Request denied by distributed store", nil)
+		t.metrics.RequestsTotal.WithLabelValues(endpoint, method, metrics.OutcomeRejectedRetriesExhausted).Inc()
+		t.metrics.WaitSeconds.Observe(time.Since(start).Seconds())
+		setRetryAfterHeader(rw, t.globalConfig.RetryAfterFormat, capRetryAfter(retryAfter, state.maxRetryAfter))
+		rw.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	t.metrics.RequestsTotal.WithLabelValues(endpoint, method, metrics.OutcomeAdmitted).Inc()
+	t.metrics.WaitSeconds.Observe(time.Since(start).Seconds())
+	t.metrics.ActiveRequests.WithLabelValues(endpoint, method).Inc()
+	defer t.metrics.ActiveRequests.WithLabelValues(endpoint, method).Dec()
+	t.next.ServeHTTP(rw, req)
+}
+
+// applyUserLimitsDistributed is the per-user counterpart of
+// applyRateLimitingDistributed: it checks a user's quota against t.store
+// instead of the in-process userStates cache, so the per-user state no
+// longer needs to live (and grow) in this process at all.
+func (t *Throttle) applyUserLimitsDistributed(rw http.ResponseWriter, endpoint, method, userID string) bool {
+	start := time.Now()
+
+	methodConfigs, methodExists := t.currentState().endpointConfigs[endpoint]
+	var endpointConfig *Config
+	if methodExists {
+		endpointConfig = methodConfigs[method]
+	}
+
+	maxRequests := t.globalConfig.UserMaxRequests
+	retryDelay := t.globalConfig.userRetryDelayDuration
+	maxRetryAfter := t.globalConfig.maxRetryAfterDuration
+	algorithm := t.globalConfig.Algorithm
+	if endpointConfig != nil {
+		if endpointConfig.UserMaxRequests > 0 {
+			maxRequests = endpointConfig.UserMaxRequests
+		}
+		retryDelay = endpointConfig.userRetryDelayDuration
+		maxRetryAfter = endpointConfig.maxRetryAfterDuration
+		algorithm = endpointConfig.Algorithm
+	}
+
+	key := fmt.Sprintf("user:%s#%s#%s", endpoint, method, userID)
+	allowed, retryAfter, err := t.admitDistributed(key, algorithm, retryDelay, maxRequests)
+	if err != nil {
+		log(LogLevelWarning, "distributed user rate limit check failed, admitting request", err)
+		t.metrics.WaitSeconds.Observe(time.Since(start).Seconds())
+		return true
+	}
+
+	if !allowed {
+		log(LogLevelDebug, fmt.Sprintf("User %s exceeded max requests", userID), nil)
+		t.metrics.RequestsTotal.WithLabelValues(endpoint, method, metrics.OutcomeRejectedUserLimit).Inc()
+		t.metrics.WaitSeconds.Observe(time.Since(start).Seconds())
+		setRetryAfterHeader(rw, t.globalConfig.RetryAfterFormat, capRetryAfter(retryAfter, maxRetryAfter))
+		rw.WriteHeader(http.StatusTooManyRequests)
+		return false
+	}
+
+	t.metrics.WaitSeconds.Observe(time.Since(start).Seconds())
+	return true
+}
+
+// admitDistributed makes a single admission decision for key against
+// t.store, using GCRA or a fixed-window counter depending on algorithm.
+func (t *Throttle) admitDistributed(key, algorithm string, emissionInterval time.Duration, burst int) (bool, time.Duration, error) {
+	if algorithm == AlgorithmGCRA {
+		return distributedGCRAAllow(t.store, key, emissionInterval, burst, time.Now())
+	}
+	return distributedCounterAllow(t.store, key, emissionInterval, burst)
+}
+
+// distributedCounterAllow implements the fixed-window counter against Store:
+// the first request in a window arms its TTL, and the window's count caps
+// admission at burst.
+func distributedCounterAllow(store Store, key string, window time.Duration, burst int) (bool, time.Duration, error) {
+	count, ttl, err := store.Incr(key, window)
+	if err != nil {
+		return false, 0, err
+	}
+	if count > int64(burst) {
+		return false, ttl, nil
+	}
+	return true, 0, nil
+}
+
+// distributedGCRAAllow runs the same GCRA admission math as gcraLimiter.allow
+// but keeps the TAT in Store instead of a local atomic pointer, CAS-retrying
+// when another instance updates the key concurrently.
+func distributedGCRAAllow(store Store, key string, emissionInterval time.Duration, burst int, now time.Time) (bool, time.Duration, error) {
+	burstOffset := emissionInterval * time.Duration(burst)
+	ttl := emissionInterval * time.Duration(burst+1)
+
+	for attempt := 0; attempt < distributedCASRetries; attempt++ {
+		raw, err := store.Get(key)
+		if err != nil {
+			return false, 0, err
+		}
+
+		tat := now
+		if len(raw) > 0 {
+			parsed, err := time.Parse(time.RFC3339Nano, string(raw))
+			if err != nil {
+				return false, 0, fmt.Errorf("corrupt tat value %q: %v", raw, err)
+			}
+			if parsed.After(tat) {
+				tat = parsed
+			}
+		}
+
+		newTAT := tat.Add(emissionInterval)
+		allowAt := newTAT.Add(-burstOffset)
+		if now.Before(allowAt) {
+			return false, allowAt.Sub(now), nil
+		}
+
+		swapped, err := store.CompareAndSwap(key, raw, []byte(newTAT.Format(time.RFC3339Nano)), ttl)
+		if err != nil {
+			return false, 0, err
+		}
+		if swapped {
+			return true, 0, nil
+		}
+		// Another instance updated the TAT first; re-read and retry.
+	}
+
+	return false, 0, fmt.Errorf("gcra cas for %q did not converge after %d attempts", key, distributedCASRetries)
+}
@@ -0,0 +1,50 @@
+package traefik_throttle
+
+import (
+	"testing"
+	"time"
+)
+
+// TestDistributedGCRACrossReplicaCollisionRejectsInsteadOfErroring reproduces
+// two Throttle instances (replicas A and B) sharing one backend Store via
+// their own cachingFallbackStore. Each replica's Get must see the other
+// replica's writes immediately, or the CAS retry loop in
+// distributedGCRAAllow spins on a stale read, exhausts distributedCASRetries,
+// and surfaces as an error that callers mistake for "store unreachable".
+func TestDistributedGCRACrossReplicaCollisionRejectsInsteadOfErroring(t *testing.T) {
+	backend := newMemoryStore()
+	replicaA := newCachingFallbackStore(backend)
+	replicaB := newCachingFallbackStore(backend)
+
+	const burst = 2
+	const interval = time.Hour // long enough that only the burst is ever admitted
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	allowed, _, err := distributedGCRAAllow(replicaB, "shared-key", interval, burst, now)
+	if err != nil {
+		t.Fatalf("replica B: unexpected error on first admission: %v", err)
+	}
+	if !allowed {
+		t.Fatal("replica B: expected the first request within burst to be admitted")
+	}
+
+	allowed, _, err = distributedGCRAAllow(replicaA, "shared-key", interval, burst, now)
+	if err != nil {
+		t.Fatalf("replica A: unexpected error on the second request within burst: %v", err)
+	}
+	if !allowed {
+		t.Fatal("replica A: expected the second request within burst to be admitted")
+	}
+
+	// The burst is now exhausted by A and B combined. Replica B's next call
+	// must see A's write and reject cleanly; if B instead served a stale
+	// cached Get from its own earlier write, the CAS loop would spin against
+	// a TAT the backend no longer has and never converge.
+	allowed, _, err = distributedGCRAAllow(replicaB, "shared-key", interval, burst, now)
+	if err != nil {
+		t.Fatalf("replica B: expected a clean rejection once the burst was exhausted, got error: %v", err)
+	}
+	if allowed {
+		t.Fatal("replica B: expected its third request to be rejected, the burst was already spent")
+	}
+}
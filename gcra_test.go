@@ -0,0 +1,42 @@
+package traefik_throttle
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGCRALimiterAllowsBurstThenThrottles(t *testing.T) {
+	const burst = 3
+	const interval = 100 * time.Millisecond
+
+	limiter := newGCRALimiter(interval, burst)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	for i := 0; i < burst; i++ {
+		if allowed, _ := limiter.allow(now); !allowed {
+			t.Fatalf("request %d: expected burst of %d to be admitted instantly", i, burst)
+		}
+	}
+
+	allowed, retryAfter := limiter.allow(now)
+	if allowed {
+		t.Fatal("expected the request past the burst to be denied")
+	}
+	if retryAfter != interval {
+		t.Fatalf("expected retryAfter == %v, got %v", interval, retryAfter)
+	}
+
+	if allowed, _ := limiter.allow(now.Add(retryAfter)); !allowed {
+		t.Fatal("expected a request after waiting retryAfter to be admitted")
+	}
+}
+
+func TestGCRALimiterDefaultsInvalidConfig(t *testing.T) {
+	limiter := newGCRALimiter(0, 0)
+	if limiter.emissionInterval <= 0 {
+		t.Fatal("expected a non-positive emission interval to fall back to a positive default")
+	}
+	if limiter.burst < 1 {
+		t.Fatal("expected a non-positive burst to fall back to at least 1")
+	}
+}
@@ -0,0 +1,313 @@
+package traefik_throttle
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// jwksCacheTTL bounds how long a fetched JWKS document is reused before
+// being re-fetched, so key rotation is picked up without refetching on
+// every request.
+const jwksCacheTTL = 5 * time.Minute
+
+// jwksFetchTimeout bounds how long fetchJWKS waits on the JWKS endpoint.
+// extractJWTClaim runs synchronously inside ServeHTTP, so without a timeout
+// a hung JWKS endpoint would block every request hitting an expired cache
+// entry indefinitely.
+const jwksFetchTimeout = 5 * time.Second
+
+// jwksHTTPClient is shared across fetchJWKS calls so they all get the same
+// bounded timeout without building a new client per request.
+var jwksHTTPClient = &http.Client{Timeout: jwksFetchTimeout}
+
+// getClientIdentity resolves the throttling identity for req by trying each
+// entry in config.IdentitySources, in order, and returning the first
+// non-empty value. An empty result means per-endpoint limiting applies, the
+// same fallback the middleware always had when no JWT was present.
+func getClientIdentity(req *http.Request, config *Config) (string, error) {
+	sources := config.IdentitySources
+	if len(sources) == 0 {
+		sources = []string{"jwt:sub"}
+	}
+
+	for _, source := range sources {
+		id, err := extractIdentity(req, config, source)
+		if err != nil {
+			log(LogLevelWarning, fmt.Sprintf("identity source %q failed", source), err)
+			continue
+		}
+		if id != "" {
+			return id, nil
+		}
+	}
+
+	return "", nil
+}
+
+// extractIdentity dispatches a single IdentitySources entry: "jwt:<claim>",
+// "header:<name>", "query:<param>", or "ip".
+func extractIdentity(req *http.Request, config *Config, source string) (string, error) {
+	switch {
+	case source == "ip":
+		return extractClientIP(req, config.IPStrategy), nil
+	case strings.HasPrefix(source, "jwt:"):
+		return extractJWTClaim(req, config, strings.TrimPrefix(source, "jwt:"))
+	case strings.HasPrefix(source, "header:"):
+		return req.Header.Get(strings.TrimPrefix(source, "header:")), nil
+	case strings.HasPrefix(source, "query:"):
+		return req.URL.Query().Get(strings.TrimPrefix(source, "query:")), nil
+	default:
+		return "", fmt.Errorf("unknown identity source %q", source)
+	}
+}
+
+// extractJWTClaim reads claim from the bearer token in the Authorization
+// header. When config.JWKSURL or config.JWTSecret is set the signature is
+// verified (RS256 via JWKS, HS256 via the shared secret); otherwise it falls
+// back to the historical unverified parse, which is a known footgun when the
+// claim feeds a per-user quota.
+func extractJWTClaim(req *http.Request, config *Config, claim string) (string, error) {
+	authHeader := req.Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return "", nil
+	}
+	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
+
+	var (
+		token *jwt.Token
+		err   error
+	)
+
+	switch {
+	case config.JWKSURL != "":
+		token, err = jwt.Parse(tokenString, jwksKeyFunc(config.JWKSURL))
+	case config.JWTSecret != "":
+		token, err = jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
+			if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+				return nil, fmt.Errorf("unexpected signing method: %v", t.Header["alg"])
+			}
+			return []byte(config.JWTSecret), nil
+		})
+	default:
+		parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+		token, _, err = parser.ParseUnverified(tokenString, jwt.MapClaims{})
+	}
+
+	if err != nil {
+		return "", nil
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", nil
+	}
+
+	value, ok := claims[claim].(string)
+	if !ok {
+		return "", nil
+	}
+
+	return value, nil
+}
+
+var (
+	jwksCacheMu sync.Mutex
+	jwksCache   = make(map[string]struct {
+		keys      map[string]*rsa.PublicKey
+		expiresAt time.Time
+	})
+)
+
+// jwksKeyFunc returns a jwt.Keyfunc that resolves RS256 keys from the JWKS
+// document at jwksURL, matching on the token's "kid" header.
+func jwksKeyFunc(jwksURL string) jwt.Keyfunc {
+	return func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		keys, err := fetchJWKS(jwksURL)
+		if err != nil {
+			return nil, err
+		}
+
+		kid, _ := token.Header["kid"].(string)
+		if kid != "" {
+			if key, ok := keys[kid]; ok {
+				return key, nil
+			}
+			return nil, fmt.Errorf("no JWKS key for kid %q", kid)
+		}
+
+		for _, key := range keys {
+			return key, nil
+		}
+		return nil, fmt.Errorf("JWKS at %s has no usable RSA keys", jwksURL)
+	}
+}
+
+// fetchJWKS downloads and parses the JWKS document at jwksURL, caching the
+// decoded RSA keys for jwksCacheTTL.
+func fetchJWKS(jwksURL string) (map[string]*rsa.PublicKey, error) {
+	jwksCacheMu.Lock()
+	if entry, ok := jwksCache[jwksURL]; ok && time.Now().Before(entry.expiresAt) {
+		jwksCacheMu.Unlock()
+		return entry.keys, nil
+	}
+	jwksCacheMu.Unlock()
+
+	resp, err := jwksHTTPClient.Get(jwksURL)
+	if err != nil {
+		return nil, fmt.Errorf("fetch jwks: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var doc struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("decode jwks: %v", err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pubKey, err := rsaPublicKeyFromJWK(k.N, k.E)
+		if err != nil {
+			log(LogLevelWarning, fmt.Sprintf("skipping malformed JWKS entry %q", k.Kid), err)
+			continue
+		}
+		keys[k.Kid] = pubKey
+	}
+
+	jwksCacheMu.Lock()
+	jwksCache[jwksURL] = struct {
+		keys      map[string]*rsa.PublicKey
+		expiresAt time.Time
+	}{keys: keys, expiresAt: time.Now().Add(jwksCacheTTL)}
+	jwksCacheMu.Unlock()
+
+	return keys, nil
+}
+
+// rsaPublicKeyFromJWK decodes the base64url-encoded modulus and exponent of
+// an RSA JWK into an *rsa.PublicKey.
+func rsaPublicKeyFromJWK(nEncoded, eEncoded string) (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(nEncoded)
+	if err != nil {
+		return nil, fmt.Errorf("decode n: %v", err)
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(eEncoded)
+	if err != nil {
+		return nil, fmt.Errorf("decode e: %v", err)
+	}
+
+	e := 0
+	for _, b := range eBytes {
+		e = e<<8 | int(b)
+	}
+
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(nBytes), E: e}, nil
+}
+
+// extractClientIP resolves the client IP per the configured IPStrategy:
+// "remote" (default) uses req.RemoteAddr, "x-real-ip" trusts X-Real-Ip,
+// "x-forwarded-for:depth=N" takes the Nth-from-the-right X-Forwarded-For
+// entry, and anything else is treated as a comma-separated list of trusted
+// proxy CIDRs to peel off the right of X-Forwarded-For.
+func extractClientIP(req *http.Request, strategy string) string {
+	switch {
+	case strategy == "" || strategy == "remote":
+		return stripPort(req.RemoteAddr)
+	case strategy == "x-real-ip":
+		return strings.TrimSpace(req.Header.Get("X-Real-Ip"))
+	case strings.HasPrefix(strategy, "x-forwarded-for"):
+		depth := 1
+		if idx := strings.Index(strategy, "depth="); idx != -1 {
+			if parsed, err := strconv.Atoi(strategy[idx+len("depth="):]); err == nil && parsed > 0 {
+				depth = parsed
+			}
+		}
+		return xForwardedForAt(req.Header.Get("X-Forwarded-For"), depth)
+	default:
+		return xForwardedForUntrusted(req, strategy)
+	}
+}
+
+func stripPort(addr string) string {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	return host
+}
+
+// xForwardedForAt returns the entry `depth` positions from the right of a
+// comma-separated X-Forwarded-For header (depth=1 is the closest proxy hop).
+func xForwardedForAt(header string, depth int) string {
+	if header == "" {
+		return ""
+	}
+
+	parts := strings.Split(header, ",")
+	idx := len(parts) - depth
+	if idx < 0 || idx >= len(parts) {
+		return ""
+	}
+
+	return strings.TrimSpace(parts[idx])
+}
+
+// xForwardedForUntrusted walks X-Forwarded-For from the right and returns
+// the first address that doesn't fall inside one of the trusted CIDRs,
+// falling back to req.RemoteAddr if every hop is trusted.
+func xForwardedForUntrusted(req *http.Request, trustedCIDRs string) string {
+	var trusted []*net.IPNet
+	for _, raw := range strings.Split(trustedCIDRs, ",") {
+		_, network, err := net.ParseCIDR(strings.TrimSpace(raw))
+		if err != nil {
+			continue
+		}
+		trusted = append(trusted, network)
+	}
+
+	parts := strings.Split(req.Header.Get("X-Forwarded-For"), ",")
+	for i := len(parts) - 1; i >= 0; i-- {
+		candidate := strings.TrimSpace(parts[i])
+		ip := net.ParseIP(candidate)
+		if ip == nil {
+			continue
+		}
+
+		trustedHop := false
+		for _, network := range trusted {
+			if network.Contains(ip) {
+				trustedHop = true
+				break
+			}
+		}
+		if !trustedHop {
+			return candidate
+		}
+	}
+
+	return stripPort(req.RemoteAddr)
+}
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"github.com/iolabs-ag/traefik-throttle/metrics"
 )
 
 const typeName = "Throttle"
@@ -15,6 +17,8 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 		config = CreateConfig()
 	}
 
+	setLogger(config.Logger)
+
 	if config.EndpointsConfigLocation != "" {
 		if err := loadConfigFromFile(config); err != nil {
 			log(LogLevelWarning, "failed to load endpoints config from file", err)
@@ -33,49 +37,163 @@ func New(ctx context.Context, next http.Handler, config *Config, name string) (h
 	}
 	config.userRetryDelayDuration = userRetryDelay
 
-	// Initialize endpoint-specific configurations
-	limits := make(map[string]map[string]*endpointState)
-	for endpoint, methodConfigs := range config.Endpoints {
-		if limits[endpoint] == nil {
-			limits[endpoint] = make(map[string]*endpointState)
+	maxRetryAfter, err := parseDurationOrDefault(config.MaxRetryAfter, 30*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid max retry after: %v", err)
+	}
+	config.maxRetryAfterDuration = maxRetryAfter
+	config.Algorithm = normalizeAlgorithm(config.Algorithm)
+
+	reloadDebounce, err := parseDurationOrDefault(config.ReloadDebounce, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reload debounce: %v", err)
+	}
+	config.reloadDebounceDuration = reloadDebounce
+
+	userStateTTL, err := parseDurationOrDefault(config.UserStateTTL, 10*time.Minute)
+	if err != nil {
+		return nil, fmt.Errorf("invalid user state TTL: %v", err)
+	}
+	config.userStateTTLDuration = userStateTTL
+
+	userStateCacheSize := config.UserStateCacheSize
+	if userStateCacheSize <= 0 {
+		userStateCacheSize = DefaultUserStateCacheSize
+	}
+
+	limits := buildEndpointLimits(config, config.Endpoints, nil)
+
+	store, err := newStore(config)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store configuration: %v", err)
+	}
+
+	metricsHandle := metrics.New()
+
+	t := &Throttle{
+		globalConfig: config,
+		next:         next,
+		name:         name,
+		userStates:   newUserStateCache(userStateCacheSize, userStateTTL, metricsHandle),
+		store:        store,
+		metrics:      metricsHandle,
+	}
+	t.state.Store(&throttleState{endpointConfigs: config.Endpoints, limits: limits})
+
+	if config.EndpointsConfigLocation != "" {
+		if err := t.startConfigWatcher(ctx); err != nil {
+			log(LogLevelWarning, "failed to start endpoints config watcher", err)
 		}
+	}
+
+	return t, nil
+}
+
+// prepareEndpointConfig resolves the duration fields and Algorithm an
+// endpoint-specific Config inherits or overrides from the global config,
+// mutating it in place. It is shared by New() and the config-file reload
+// path so both apply the exact same defaulting rules.
+func prepareEndpointConfig(global, endpointConfig *Config) {
+	retryDelay, err := parseDurationOrDefault(endpointConfig.RetryDelay, time.Millisecond)
+	if err != nil {
+		log(LogLevelWarning, "invalid retry delay for endpoint", err)
+		retryDelay = time.Millisecond
+	}
+	endpointConfig.retryDelayDuration = retryDelay
+
+	userRetryDelay, err := parseDurationOrDefault(endpointConfig.UserRetryDelay, time.Second)
+	if err != nil {
+		log(LogLevelWarning, "invalid user retry delay for endpoint", err)
+		userRetryDelay = time.Second
+	}
+	endpointConfig.userRetryDelayDuration = userRetryDelay
+
+	maxRetryAfter, err := parseDurationOrDefault(endpointConfig.MaxRetryAfter, global.maxRetryAfterDuration)
+	if err != nil {
+		log(LogLevelWarning, "invalid max retry after for endpoint", err)
+		maxRetryAfter = global.maxRetryAfterDuration
+	}
+	endpointConfig.maxRetryAfterDuration = maxRetryAfter
+
+	if endpointConfig.Algorithm == "" {
+		endpointConfig.Algorithm = global.Algorithm
+	} else {
+		endpointConfig.Algorithm = normalizeAlgorithm(endpointConfig.Algorithm)
+	}
+}
+
+// newEndpointState builds the endpointState backing an endpoint-specific
+// Config that has already been through prepareEndpointConfig.
+func newEndpointState(endpointConfig *Config) *endpointState {
+	state := &endpointState{
+		maxRequests:   endpointConfig.MaxRequests,
+		maxQueue:      endpointConfig.MaxQueue,
+		retryCount:    endpointConfig.RetryCount,
+		retryDelay:    endpointConfig.retryDelayDuration,
+		maxRetryAfter: endpointConfig.maxRetryAfterDuration,
+		algorithm:     endpointConfig.Algorithm,
+	}
+	if state.algorithm == AlgorithmGCRA {
+		state.gcra = newGCRALimiter(state.retryDelay, state.maxRequests)
+	}
+	return state
+}
+
+// buildEndpointLimits resolves every endpoint/method Config under endpoints
+// into an endpointState, reusing an existing state from previous when
+// possible so in-flight counts (or, for GCRA, burst budget) survive a config
+// reload instead of resetting to full.
+func buildEndpointLimits(global *Config, endpoints map[string]map[string]*Config, previous map[string]map[string]*endpointState) map[string]map[string]*endpointState {
+	limits := make(map[string]map[string]*endpointState)
+	for endpoint, methodConfigs := range endpoints {
+		limits[endpoint] = make(map[string]*endpointState)
 		for method, endpointConfig := range methodConfigs {
-			retryDelay, err := parseDurationOrDefault(endpointConfig.RetryDelay, time.Millisecond)
-			if err != nil {
-				log(LogLevelWarning, "invalid retry delay for endpoint", err)
-				retryDelay = time.Millisecond
+			prepareEndpointConfig(global, endpointConfig)
+
+			existing, hasExisting := previous[endpoint][method]
+
+			// Rescaling a GCRA limiter's emission interval/burst in place would
+			// race with its lock-free CAS loop, and its endpointState's other
+			// fields (e.g. maxRetryAfter) are read without a lock too, so the
+			// only safe reuse is the existing state completely unmodified —
+			// and only when every field it reads is unchanged. Otherwise a
+			// fresh state (and a refilled burst budget) is unavoidable.
+			if hasExisting && existing.algorithm == AlgorithmGCRA && endpointConfig.Algorithm == AlgorithmGCRA &&
+				existing.retryDelay == endpointConfig.retryDelayDuration &&
+				existing.maxRequests == endpointConfig.MaxRequests &&
+				existing.maxRetryAfter == endpointConfig.maxRetryAfterDuration {
+				limits[endpoint][method] = existing
+				continue
 			}
-			endpointConfig.retryDelayDuration = retryDelay
 
-			userRetryDelay, err := parseDurationOrDefault(endpointConfig.UserRetryDelay, time.Second)
-			if err != nil {
-				log(LogLevelWarning, "invalid user retry delay for endpoint", err)
-				userRetryDelay = time.Second
+			// The counter algorithm guards every field with existing.mutex, so
+			// it can rescale its existing state across a reload instead.
+			if hasExisting && existing.algorithm == AlgorithmCounter && endpointConfig.Algorithm == AlgorithmCounter {
+				existing.mutex.Lock()
+				existing.maxRequests = endpointConfig.MaxRequests
+				existing.maxQueue = endpointConfig.MaxQueue
+				existing.retryCount = endpointConfig.RetryCount
+				existing.retryDelay = endpointConfig.retryDelayDuration
+				existing.maxRetryAfter = endpointConfig.maxRetryAfterDuration
+				existing.mutex.Unlock()
+				limits[endpoint][method] = existing
+				continue
 			}
-			endpointConfig.userRetryDelayDuration = userRetryDelay
 
-			limits[endpoint][method] = &endpointState{
-				maxRequests: endpointConfig.MaxRequests,
-				maxQueue:    endpointConfig.MaxQueue,
-				retryCount:  endpointConfig.RetryCount,
-				retryDelay:  retryDelay,
-			}
+			limits[endpoint][method] = newEndpointState(endpointConfig)
 		}
 	}
-
-	return &Throttle{
-		globalConfig:    config,
-		endpointConfigs: config.Endpoints,
-		next:            next,
-		name:            name,
-		limits:          limits,
-		userLimits:      make(map[string]map[string]*UserState),
-	}, nil
+	return limits
 }
 
 // ServeHTTP handles the incoming HTTP requests and applies rate limiting.
 func (t *Throttle) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
-	userID, _ := getUserIDFromJWT(req)
+	if t.globalConfig.MetricsPath != "" && req.URL.Path == t.globalConfig.MetricsPath {
+		t.metrics.Handler().ServeHTTP(rw, req)
+		return
+	}
+
+	userID, _ := getClientIdentity(req, t.globalConfig)
 
 	if userID == "" {
 		rw.Header().Add("x-throttle-level", "endpoint")
@@ -96,42 +214,51 @@ func (t *Throttle) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 
 // applyUserLimits applies rate limiting for a specific user, including a queuing mechanism.
 func (t *Throttle) applyUserLimits(rw http.ResponseWriter, endpoint, method, userID string) bool {
-	key := fmt.Sprintf("%s#%s", endpoint, method)
-	t.limitsLock.RLock()
-	if _, exists := t.userLimits[key]; !exists {
-		t.limitsLock.RUnlock()
-		t.limitsLock.Lock()
-		if _, exists := t.userLimits[key]; !exists {
-			t.userLimits[key] = make(map[string]*UserState)
-		}
-		t.limitsLock.Unlock()
-		t.limitsLock.RLock()
+	if t.globalConfig.Store == StoreRedis {
+		return t.applyUserLimitsDistributed(rw, endpoint, method, userID)
 	}
 
-	userState, exists := t.userLimits[key][userID]
-	t.limitsLock.RUnlock()
-	if !exists {
-		methodConfigs, methodExists := t.endpointConfigs[endpoint]
+	key := fmt.Sprintf("%s#%s#%s", endpoint, method, userID)
+	userState := t.userStates.getOrCreate(key, func() *UserState {
+		methodConfigs, methodExists := t.currentState().endpointConfigs[endpoint]
 		var endpointConfig *Config
 		if methodExists {
 			endpointConfig = methodConfigs[method]
 		}
 		maxRequests := t.globalConfig.UserMaxRequests
 		retryDelay := t.globalConfig.userRetryDelayDuration
+		maxRetryAfter := t.globalConfig.maxRetryAfterDuration
+		algorithm := t.globalConfig.Algorithm
 		if endpointConfig != nil {
 			if endpointConfig.UserMaxRequests > 0 {
 				maxRequests = endpointConfig.UserMaxRequests
 			}
 			retryDelay = endpointConfig.userRetryDelayDuration
+			maxRetryAfter = endpointConfig.maxRetryAfterDuration
+			algorithm = endpointConfig.Algorithm
 		}
-		userState = &UserState{
+		userState := &UserState{
 			maxRequests:   maxRequests,
 			retryDelay:    retryDelay,
-			requestsCount: 0,
+			maxRetryAfter: maxRetryAfter,
+			algorithm:     algorithm,
 		}
-		t.limitsLock.Lock()
-		t.userLimits[key][userID] = userState
-		t.limitsLock.Unlock()
+		if userState.algorithm == AlgorithmGCRA {
+			userState.gcra = newGCRALimiter(retryDelay, maxRequests)
+		}
+		return userState
+	})
+
+	if userState.algorithm == AlgorithmGCRA {
+		allowed, retryAfter := userState.gcra.allow(time.Now())
+		if !allowed {
+			log(LogLevelDebug, fmt.Sprintf("User %s exceeded max requests", userID), nil)
+			t.metrics.RequestsTotal.WithLabelValues(endpoint, method, metrics.OutcomeRejectedUserLimit).Inc()
+			setRetryAfterHeader(rw, t.globalConfig.RetryAfterFormat, capRetryAfter(retryAfter, userState.maxRetryAfter))
+			rw.WriteHeader(http.StatusTooManyRequests)
+			return false
+		}
+		return true
 	}
 
 	// Manage user state with minimal lock time
@@ -140,15 +267,15 @@ func (t *Throttle) applyUserLimits(rw http.ResponseWriter, endpoint, method, use
 
 	if userState.requestsCount >= userState.maxRequests {
 		log(LogLevelDebug, fmt.Sprintf("User %s exceeded max requests", userID), nil)
+		t.metrics.RequestsTotal.WithLabelValues(endpoint, method, metrics.OutcomeRejectedUserLimit).Inc()
+		retryAfter := backoffWithJitter(userState.retryDelay, 0, 0, userState.maxRetryAfter)
+		setRetryAfterHeader(rw, t.globalConfig.RetryAfterFormat, retryAfter)
 		rw.WriteHeader(http.StatusTooManyRequests)
 		return false
 	}
 
 	userState.requestsCount++
-	time.AfterFunc(userState.retryDelay, func() {
-		t.limitsLock.Lock()
-		defer t.limitsLock.Unlock()
-
+	userState.timer = time.AfterFunc(userState.retryDelay, func() {
 		userState.mutex.Lock()
 		userState.requestsCount--
 		userState.mutex.Unlock()
@@ -159,6 +286,19 @@ func (t *Throttle) applyUserLimits(rw http.ResponseWriter, endpoint, method, use
 
 // applyRateLimiting applies rate limiting to requests for a specific endpoint and method.
 func (t *Throttle) applyRateLimiting(rw http.ResponseWriter, req *http.Request, state *endpointState) {
+	if t.globalConfig.Store == StoreRedis {
+		t.applyRateLimitingDistributed(rw, req, state, fmt.Sprintf("endpoint:%s#%s", req.URL.Path, req.Method))
+		return
+	}
+
+	if state.algorithm == AlgorithmGCRA {
+		t.applyGCRARateLimiting(rw, req, state)
+		return
+	}
+
+	endpoint, method := req.URL.Path, req.Method
+	start := time.Now()
+
 	attempt := state.retryCount
 	queued := false
 	incrementedQueue := false
@@ -170,12 +310,23 @@ func (t *Throttle) applyRateLimiting(rw http.ResponseWriter, req *http.Request,
 			if queued {
 				state.queueCount--
 			}
+			queueDepth := state.queueCount
 			state.mutex.Unlock()
 
+			outcome := metrics.OutcomeAdmitted
+			if queued {
+				outcome = metrics.OutcomeQueuedAdmitted
+			}
+			t.metrics.RequestsTotal.WithLabelValues(endpoint, method, outcome).Inc()
+			t.metrics.QueueDepth.WithLabelValues(endpoint, method).Set(float64(queueDepth))
+			t.metrics.ActiveRequests.WithLabelValues(endpoint, method).Inc()
+			t.metrics.WaitSeconds.Observe(time.Since(start).Seconds())
+
 			defer func() {
 				state.mutex.Lock()
 				state.requestsCount--
 				state.mutex.Unlock()
+				t.metrics.ActiveRequests.WithLabelValues(endpoint, method).Dec()
 			}()
 
 			t.next.ServeHTTP(rw, req)
@@ -187,6 +338,7 @@ func (t *Throttle) applyRateLimiting(rw http.ResponseWriter, req *http.Request,
 				state.queueCount++
 				incrementedQueue = true
 				queued = true
+				t.metrics.QueueDepth.WithLabelValues(endpoint, method).Set(float64(state.queueCount))
 			}
 		} else {
 			state.mutex.Unlock()
@@ -197,7 +349,12 @@ func (t *Throttle) applyRateLimiting(rw http.ResponseWriter, req *http.Request,
 
 		if state.queueCount >= state.maxQueue {
 			log(LogLevelDebug, "Queue limit reached for endpoint", nil)
+			queueDepth := state.queueCount
 			state.mutex.Unlock()
+			t.metrics.RequestsTotal.WithLabelValues(endpoint, method, metrics.OutcomeRejectedQueueFull).Inc()
+			t.metrics.WaitSeconds.Observe(time.Since(start).Seconds())
+			retryAfter := backoffWithJitter(state.retryDelay, state.retryCount-attempt, queueDepth, state.maxRetryAfter)
+			setRetryAfterHeader(rw, t.globalConfig.RetryAfterFormat, retryAfter)
 			rw.WriteHeader(http.StatusTooManyRequests)
 			return
 		}
@@ -211,22 +368,54 @@ func (t *Throttle) applyRateLimiting(rw http.ResponseWriter, req *http.Request,
 		state.mutex.Lock()
 		state.queueCount--
 		state.mutex.Unlock()
+		t.metrics.QueueDepth.WithLabelValues(endpoint, method).Set(float64(state.queueCount))
 	}
 
 	log(LogLevelDebug, "Request denied after all retry attempts", nil)
+	t.metrics.RequestsTotal.WithLabelValues(endpoint, method, metrics.OutcomeRejectedRetriesExhausted).Inc()
+	t.metrics.WaitSeconds.Observe(time.Since(start).Seconds())
+	retryAfter := backoffWithJitter(state.retryDelay, state.retryCount, state.queueCount, state.maxRetryAfter)
+	setRetryAfterHeader(rw, t.globalConfig.RetryAfterFormat, retryAfter)
 	rw.WriteHeader(http.StatusTooManyRequests)
 }
 
+// applyGCRARateLimiting admits or rejects a request using the GCRA limiter
+// instead of the counter-and-queue scheme. GCRA makes its decision in a
+// single CAS and needs no queueing: a rejection already carries the exact
+// wait time a client needs before it would be admitted.
+func (t *Throttle) applyGCRARateLimiting(rw http.ResponseWriter, req *http.Request, state *endpointState) {
+	endpoint, method := req.URL.Path, req.Method
+	start := time.Now()
+
+	allowed, retryAfter := state.gcra.allow(start)
+	if !allowed {
+		log(LogLevelDebug, "Request denied by GCRA limiter", nil)
+		t.metrics.RequestsTotal.WithLabelValues(endpoint, method, metrics.OutcomeRejectedRetriesExhausted).Inc()
+		t.metrics.WaitSeconds.Observe(time.Since(start).Seconds())
+		setRetryAfterHeader(rw, t.globalConfig.RetryAfterFormat, capRetryAfter(retryAfter, state.maxRetryAfter))
+		rw.WriteHeader(http.StatusTooManyRequests)
+		return
+	}
+
+	t.metrics.RequestsTotal.WithLabelValues(endpoint, method, metrics.OutcomeAdmitted).Inc()
+	t.metrics.WaitSeconds.Observe(time.Since(start).Seconds())
+	t.metrics.ActiveRequests.WithLabelValues(endpoint, method).Inc()
+	defer t.metrics.ActiveRequests.WithLabelValues(endpoint, method).Dec()
+	t.next.ServeHTTP(rw, req)
+}
+
 // getEndpointConfig retrieves the rate limiting configuration for a specific endpoint and method.
 func (t *Throttle) getEndpointConfig(path, method string) *endpointState {
+	limits := t.currentState().limits
+
 	t.limitsLock.RLock()
-	methodStates, exists := t.limits[path]
+	methodStates, exists := limits[path]
 	t.limitsLock.RUnlock()
 	if !exists {
 		t.limitsLock.Lock()
-		if _, exists := t.limits[path]; !exists {
+		if _, exists := limits[path]; !exists {
 			methodStates = make(map[string]*endpointState)
-			t.limits[path] = methodStates
+			limits[path] = methodStates
 		}
 		t.limitsLock.Unlock()
 	}
@@ -236,10 +425,15 @@ func (t *Throttle) getEndpointConfig(path, method string) *endpointState {
 	t.limitsLock.RUnlock()
 	if !exists {
 		state = &endpointState{
-			maxRequests: t.globalConfig.MaxRequests,
-			maxQueue:    t.globalConfig.MaxQueue,
-			retryCount:  t.globalConfig.RetryCount,
-			retryDelay:  t.globalConfig.retryDelayDuration,
+			maxRequests:   t.globalConfig.MaxRequests,
+			maxQueue:      t.globalConfig.MaxQueue,
+			retryCount:    t.globalConfig.RetryCount,
+			retryDelay:    t.globalConfig.retryDelayDuration,
+			maxRetryAfter: t.globalConfig.maxRetryAfterDuration,
+			algorithm:     t.globalConfig.Algorithm,
+		}
+		if state.algorithm == AlgorithmGCRA {
+			state.gcra = newGCRALimiter(t.globalConfig.retryDelayDuration, t.globalConfig.MaxRequests)
 		}
 		t.limitsLock.Lock()
 		methodStates[method] = state
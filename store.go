@@ -0,0 +1,243 @@
+package traefik_throttle
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// StoreMemory and StoreRedis select the backend that holds rate-limiting
+// counters and GCRA TAT values.
+const (
+	StoreMemory = "memory"
+	StoreRedis  = "redis"
+)
+
+// Store abstracts the distributed backend used to share rate-limiting state
+// across Throttle instances, e.g. a redisStore so every Traefik replica
+// enforces the same budget. There is no Store for StoreMemory: that mode
+// keeps today's single-instance behavior via the in-process endpointState
+// and UserState structs directly, so t.store is only ever consulted when
+// globalConfig.Store == StoreRedis.
+type Store interface {
+	// Incr atomically increments key's counter, arming a TTL of window on
+	// the increment that creates the key, and returns the resulting count
+	// and the counter's remaining TTL.
+	Incr(key string, window time.Duration) (count int64, ttl time.Duration, err error)
+	// Get returns the raw value stored at key, or a nil slice if it does not exist.
+	Get(key string) ([]byte, error)
+	// CompareAndSwap atomically replaces old with new at key and arms ttl,
+	// reporting whether the swap happened. A nil old matches a missing key.
+	CompareAndSwap(key string, old, new []byte, ttl time.Duration) (bool, error)
+}
+
+// newStore builds the Store selected by config.Store. It returns a nil Store
+// for the memory default, since that mode never reads t.store at all.
+func newStore(config *Config) (Store, error) {
+	switch config.Store {
+	case "", StoreMemory:
+		return nil, nil
+	case StoreRedis:
+		backend := newRedisStore(config.RedisAddr, config.RedisPassword, config.RedisDB, config.KeyPrefix)
+		return newCachingFallbackStore(backend), nil
+	default:
+		return nil, fmt.Errorf("unknown store %q", config.Store)
+	}
+}
+
+// memoryEntry is a single counter or CAS value held by memoryStore.
+type memoryEntry struct {
+	value     []byte
+	count     int64
+	expiresAt time.Time
+}
+
+// memoryStore is a Store backed by process-local state. newStore never
+// selects it directly; it exists solely as the fallback destination for a
+// cachingFallbackStore when the distributed backend is unreachable.
+type memoryStore struct {
+	mutex   sync.Mutex
+	entries map[string]*memoryEntry
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+func (m *memoryStore) Incr(key string, window time.Duration) (int64, time.Duration, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	entry, exists := m.entries[key]
+	if !exists || now.After(entry.expiresAt) {
+		entry = &memoryEntry{expiresAt: now.Add(window)}
+		m.entries[key] = entry
+	}
+	entry.count++
+	return entry.count, entry.expiresAt.Sub(now), nil
+}
+
+func (m *memoryStore) Get(key string) ([]byte, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry, exists := m.entries[key]
+	if !exists || time.Now().After(entry.expiresAt) {
+		return nil, nil
+	}
+	return entry.value, nil
+}
+
+func (m *memoryStore) CompareAndSwap(key string, old, new []byte, ttl time.Duration) (bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry, exists := m.entries[key]
+	if exists && time.Now().After(entry.expiresAt) {
+		exists = false
+	}
+
+	var current []byte
+	if exists {
+		current = entry.value
+	}
+	if string(current) != string(old) {
+		return false, nil
+	}
+
+	m.entries[key] = &memoryEntry{value: new, expiresAt: time.Now().Add(ttl)}
+	return true, nil
+}
+
+// redisStore is a Store backed by Redis. Incr and CompareAndSwap each run as
+// a single Lua script so the read-modify-write is atomic server-side.
+type redisStore struct {
+	client    *redis.Client
+	keyPrefix string
+}
+
+func newRedisStore(addr, password string, db int, keyPrefix string) *redisStore {
+	return &redisStore{
+		client: redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: password,
+			DB:       db,
+		}),
+		keyPrefix: keyPrefix,
+	}
+}
+
+var redisIncrScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {count, redis.call("PTTL", KEYS[1])}
+`)
+
+func (r *redisStore) Incr(key string, window time.Duration) (int64, time.Duration, error) {
+	res, err := redisIncrScript.Run(context.Background(), r.client, []string{r.prefixed(key)}, window.Milliseconds()).Result()
+	if err != nil {
+		return 0, 0, fmt.Errorf("redis incr: %v", err)
+	}
+
+	vals, ok := res.([]interface{})
+	if !ok || len(vals) != 2 {
+		return 0, 0, fmt.Errorf("redis incr: unexpected reply %v", res)
+	}
+	count, _ := vals[0].(int64)
+	ttlMillis, _ := vals[1].(int64)
+	return count, time.Duration(ttlMillis) * time.Millisecond, nil
+}
+
+func (r *redisStore) Get(key string) ([]byte, error) {
+	val, err := r.client.Get(context.Background(), r.prefixed(key)).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis get: %v", err)
+	}
+	return val, nil
+}
+
+// redisCASScript only distinguishes "missing key" from "empty value" via
+// ARGV[1] == "": a tat/count legitimately serialized to the empty string
+// would be indistinguishable from a missing key. GCRA TATs are always
+// non-empty timestamps, so this tradeoff is safe for our callers.
+var redisCASScript = redis.NewScript(`
+local current = redis.call("GET", KEYS[1])
+if ARGV[1] == "" then
+	if current then return 0 end
+else
+	if not current or current ~= ARGV[1] then return 0 end
+end
+redis.call("SET", KEYS[1], ARGV[2], "PX", ARGV[3])
+return 1
+`)
+
+func (r *redisStore) CompareAndSwap(key string, old, new []byte, ttl time.Duration) (bool, error) {
+	res, err := redisCASScript.Run(context.Background(), r.client, []string{r.prefixed(key)}, string(old), string(new), ttl.Milliseconds()).Result()
+	if err != nil {
+		return false, fmt.Errorf("redis cas: %v", err)
+	}
+	swapped, _ := res.(int64)
+	return swapped == 1, nil
+}
+
+func (r *redisStore) prefixed(key string) string {
+	return r.keyPrefix + key
+}
+
+// cachingFallbackStore sits in front of a distributed Store, falling back to
+// an in-process memoryStore (logging a warning) whenever the distributed
+// backend errors, e.g. because Redis is unreachable. Tradeoff: during an
+// outage each instance enforces its own local budget, so the effective
+// limit can briefly multiply by the replica count until Redis recovers.
+//
+// Get deliberately does not cache: its only caller is distributedGCRAAllow's
+// CAS retry loop, and serving a retry from a local cache instead of the
+// backend means the loop keeps comparing against its own stale read instead
+// of the value another replica just swapped in — it never converges and
+// every collision between replicas surfaces as a spurious "store
+// unreachable" error that fails open. Read-modify-write state can't be
+// cached; only a plain read that isn't feeding a CAS could be.
+type cachingFallbackStore struct {
+	primary  Store
+	fallback Store
+}
+
+func newCachingFallbackStore(primary Store) *cachingFallbackStore {
+	return &cachingFallbackStore{primary: primary, fallback: newMemoryStore()}
+}
+
+func (c *cachingFallbackStore) Incr(key string, window time.Duration) (int64, time.Duration, error) {
+	count, ttl, err := c.primary.Incr(key, window)
+	if err != nil {
+		log(LogLevelWarning, "distributed store unreachable, falling back to local rate limiting", err)
+		return c.fallback.Incr(key, window)
+	}
+	return count, ttl, nil
+}
+
+func (c *cachingFallbackStore) Get(key string) ([]byte, error) {
+	value, err := c.primary.Get(key)
+	if err != nil {
+		log(LogLevelWarning, "distributed store unreachable, falling back to local rate limiting", err)
+		return c.fallback.Get(key)
+	}
+	return value, nil
+}
+
+func (c *cachingFallbackStore) CompareAndSwap(key string, old, new []byte, ttl time.Duration) (bool, error) {
+	swapped, err := c.primary.CompareAndSwap(key, old, new, ttl)
+	if err != nil {
+		log(LogLevelWarning, "distributed store unreachable, falling back to local rate limiting", err)
+		return c.fallback.CompareAndSwap(key, old, new, ttl)
+	}
+	return swapped, nil
+}
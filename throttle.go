@@ -1,22 +1,55 @@
+// Package traefik_throttle implements a rate-limiting middleware exposing
+// the standard Traefik plugin entrypoint (New, Config, CreateConfig).
+//
+// Deployment note: this package imports fsnotify, prometheus/client_golang,
+// and redis/go-redis/v9 (see the config-file watcher, metrics and Redis
+// Store). Traefik's plugin loader runs local and catalog plugins through
+// Yaegi, its Go interpreter, which only supports the standard library plus
+// the plugin's own sub-packages — it cannot load any of those three. An
+// installation that needs the Redis store, the config watcher, or the
+// metrics endpoint has to build this package into Traefik as a compiled
+// middleware rather than load it through the Yaegi plugin loader; an
+// installation that must stay on the Yaegi-loadable path should pin to a
+// commit before these were introduced.
 package traefik_throttle
 
 import (
 	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/iolabs-ag/traefik-throttle/metrics"
 )
 
 // Throttle struct holds the global and endpoint-specific configurations,
 // as well as the state for rate limiting.
 type Throttle struct {
-	globalConfig    *Config
-	endpointConfigs map[string]map[string]*Config // Per-endpoint configurations by method
-	next            http.Handler
-	name            string
+	globalConfig *Config
+	next         http.Handler
+	name         string
+
+	state atomic.Pointer[throttleState] // endpoint configs and limits, swapped atomically on reload
 
-	limits     map[string]map[string]*endpointState // Supports per-method state
-	userLimits map[string]map[string]*UserState     // Per-endpoint and method user limits
+	userStates *userStateCache // bounded, TTL+LRU cache of per-(endpoint,method,user) state
 	limitsLock sync.RWMutex
+
+	store Store // backs cross-instance state when globalConfig.Store == StoreRedis
+
+	metrics *metrics.Metrics
+}
+
+// throttleState bundles the pieces of Throttle that a config file reload
+// replaces together, so a reload is a single atomic pointer swap instead of
+// a sequence of map mutations an in-flight request could observe half-done.
+type throttleState struct {
+	endpointConfigs map[string]map[string]*Config        // Per-endpoint configurations by method
+	limits          map[string]map[string]*endpointState // Supports per-method state
+}
+
+// currentState returns the Throttle's current endpoint configs and limits.
+func (t *Throttle) currentState() *throttleState {
+	return t.state.Load()
 }
 
 // endpointState struct holds the state for each endpoint's rate limiting.
@@ -26,15 +59,29 @@ type endpointState struct {
 
 	retryCount    int
 	retryDelay    time.Duration
+	maxRetryAfter time.Duration
 	requestsCount int
 	queueCount    int
 	mutex         sync.RWMutex
+
+	algorithm string       // AlgorithmCounter or AlgorithmGCRA
+	gcra      *gcraLimiter // set when algorithm == AlgorithmGCRA
 }
 
 // UserState struct holds the state for each user's rate limiting.
 type UserState struct {
 	maxRequests   int
 	retryDelay    time.Duration
+	maxRetryAfter time.Duration
 	requestsCount int
 	mutex         sync.RWMutex
+
+	algorithm string
+	gcra      *gcraLimiter
+
+	// timer is the most recently armed decrement callback for the counter
+	// algorithm, if any. userStateCache stops it on eviction; callbacks armed
+	// by earlier requests are not tracked and still fire, decrementing this
+	// evicted UserState rather than any new one created for the same key.
+	timer *time.Timer
 }
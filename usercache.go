@@ -0,0 +1,105 @@
+package traefik_throttle
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/iolabs-ag/traefik-throttle/metrics"
+)
+
+// DefaultUserStateCacheSize bounds the number of tracked per-user rate limit
+// states when Config.UserStateCacheSize is unset, matching the cap used by
+// comparable middlewares.
+const DefaultUserStateCacheSize = 65536
+
+// userStateCache is a bounded, TTL-aware LRU cache of UserState keyed by
+// "endpoint#method#userID". Without a cap, Throttle.userStates would grow
+// forever as new distinct users hit the service; this trades a bit of
+// accuracy for idle users reappearing with a clean state for a hard ceiling
+// on memory use.
+type userStateCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[string]*list.Element
+	order    *list.List // front = most recently used
+	metrics  *metrics.Metrics
+}
+
+// userCacheEntry is the value stored in userStateCache.order.
+type userCacheEntry struct {
+	key      string
+	state    *UserState
+	expireAt time.Time
+}
+
+// newUserStateCache creates a cache bounded to capacity entries, each
+// evicted after ttl of inactivity.
+func newUserStateCache(capacity int, ttl time.Duration, m *metrics.Metrics) *userStateCache {
+	return &userStateCache{
+		capacity: capacity,
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+		metrics:  m,
+	}
+}
+
+// getOrCreate returns the UserState for key, calling create to build one if
+// key is absent or its previous entry's TTL has lapsed. Every access refreshes
+// the entry's TTL and its position in the LRU order; inserting past capacity
+// evicts the least-recently-used entry.
+func (c *userStateCache) getOrCreate(key string, create func() *UserState) *UserState {
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*userCacheEntry)
+		if now.Before(entry.expireAt) {
+			entry.expireAt = now.Add(c.ttl)
+			c.order.MoveToFront(elem)
+			return entry.state
+		}
+		c.removeElementLocked(elem)
+	}
+
+	entry := &userCacheEntry{key: key, state: create(), expireAt: now.Add(c.ttl)}
+	c.entries[key] = c.order.PushFront(entry)
+
+	for c.order.Len() > c.capacity {
+		if elem := c.order.Back(); elem != nil {
+			c.removeElementLocked(elem)
+		}
+	}
+
+	if c.metrics != nil {
+		c.metrics.UserStates.Set(float64(c.order.Len()))
+	}
+
+	return entry.state
+}
+
+// removeElementLocked drops elem from the cache and stops its UserState's
+// most recently armed decrement timer, if any. Earlier pending decrements are
+// not stopped; they act on the evicted UserState only, never on one later
+// created for this key. Callers must hold c.mu.
+func (c *userStateCache) removeElementLocked(elem *list.Element) {
+	entry := elem.Value.(*userCacheEntry)
+
+	entry.state.mutex.Lock()
+	timer := entry.state.timer
+	entry.state.mutex.Unlock()
+	if timer != nil {
+		timer.Stop()
+	}
+
+	delete(c.entries, entry.key)
+	c.order.Remove(elem)
+
+	if c.metrics != nil {
+		c.metrics.UserEvictions.Inc()
+	}
+}
@@ -0,0 +1,58 @@
+package traefik_throttle
+
+import (
+	"testing"
+	"time"
+
+	"github.com/iolabs-ag/traefik-throttle/metrics"
+)
+
+func newTestCounterState() *UserState {
+	return &UserState{algorithm: AlgorithmCounter}
+}
+
+func TestUserStateCacheEvictsLRUAndStopsTimer(t *testing.T) {
+	cache := newUserStateCache(2, time.Hour, metrics.New())
+
+	a := cache.getOrCreate("a", newTestCounterState)
+	a.timer = time.AfterFunc(time.Hour, func() {})
+	cache.getOrCreate("b", newTestCounterState)
+
+	// Capacity is 2, so inserting a third key evicts "a", the
+	// least-recently-used entry.
+	cache.getOrCreate("c", newTestCounterState)
+
+	if a.timer.Stop() {
+		t.Fatal("expected userStateCache to have already stopped a's decrement timer on eviction")
+	}
+
+	evicted := cache.getOrCreate("a", newTestCounterState)
+	if evicted == a {
+		t.Fatal("expected eviction to have dropped \"a\", but getOrCreate returned the evicted instance")
+	}
+}
+
+func TestUserStateCacheKeepsRecentlyUsedEntryOnEviction(t *testing.T) {
+	cache := newUserStateCache(2, time.Hour, metrics.New())
+
+	a := cache.getOrCreate("a", newTestCounterState)
+	cache.getOrCreate("b", newTestCounterState)
+	cache.getOrCreate("a", newTestCounterState) // touch "a" so "b" becomes the LRU entry
+	cache.getOrCreate("c", newTestCounterState) // evicts "b", not "a"
+
+	if got := cache.getOrCreate("a", newTestCounterState); got != a {
+		t.Fatal("expected the recently-touched entry to survive eviction")
+	}
+}
+
+func TestUserStateCacheExpiresPastTTL(t *testing.T) {
+	cache := newUserStateCache(10, time.Millisecond, metrics.New())
+
+	first := cache.getOrCreate("user", newTestCounterState)
+	time.Sleep(5 * time.Millisecond)
+	second := cache.getOrCreate("user", newTestCounterState)
+
+	if first == second {
+		t.Fatal("expected a TTL-expired entry to be replaced with a fresh UserState")
+	}
+}
@@ -1,10 +1,13 @@
 package traefik_throttle
 
 import (
+	"context"
 	"fmt"
-	"github.com/golang-jwt/jwt/v4"
+	"log/slog"
+	"math/rand"
 	"net/http"
-	"strings"
+	"strconv"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,8 +18,35 @@ const (
 	LogLevelError   = "ERROR"
 )
 
-// log logs messages with a specific log level and context.
+// activeLogger holds the structured logger set via Config.Logger, if any.
+// It defaults to nil, in which case log() keeps the historical stdout
+// behavior so the plugin keeps working when loaded from static YAML, which
+// cannot express a slog.Handler.
+var activeLogger atomic.Pointer[slog.Logger]
+
+// setLogger installs handler as the destination for log(), or reverts to
+// the default stdout behavior when handler is nil.
+func setLogger(handler slog.Handler) {
+	if handler == nil {
+		activeLogger.Store(nil)
+		return
+	}
+	activeLogger.Store(slog.New(handler))
+}
+
+// log logs messages with a specific log level and context, through the
+// structured logger installed by setLogger when one is configured,
+// otherwise to stdout in the plugin's original format.
 func log(level, message string, err error) {
+	if logger := activeLogger.Load(); logger != nil {
+		if err != nil {
+			logger.Log(context.Background(), slogLevel(level), message, "error", err)
+		} else {
+			logger.Log(context.Background(), slogLevel(level), message)
+		}
+		return
+	}
+
 	if err != nil {
 		fmt.Printf("[%s]: %s: %v\n", level, message, err)
 	} else {
@@ -24,6 +54,20 @@ func log(level, message string, err error) {
 	}
 }
 
+// slogLevel maps this package's log levels onto slog's.
+func slogLevel(level string) slog.Level {
+	switch level {
+	case LogLevelDebug:
+		return slog.LevelDebug
+	case LogLevelWarning:
+		return slog.LevelWarn
+	case LogLevelError:
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
+	}
+}
+
 // parseDurationOrDefault parses a duration string or returns a default value on failure.
 func parseDurationOrDefault(value string, defaultDuration time.Duration) (time.Duration, error) {
 	if value == "" {
@@ -45,29 +89,54 @@ func parseDurationWithFallback(value string, fallback time.Duration) time.Durati
 	return d
 }
 
-// getUserIDFromJWT extracts the user ID from the JWT token in the request.
-func getUserIDFromJWT(req *http.Request) (string, error) {
-	authHeader := req.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		return "", nil
+// backoffWithJitter computes a Retry-After delay using exponential backoff
+// seeded by the number of retry attempts already spent and the current queue
+// depth, plus random jitter in [0, base). The result is capped at maxDelay to
+// avoid handing out unreasonably large hints to clients.
+func backoffWithJitter(base time.Duration, attemptsUsed, queueDepth int, maxDelay time.Duration) time.Duration {
+	if base <= 0 {
+		base = time.Millisecond
 	}
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
-	token, _, err := parser.ParseUnverified(tokenString, jwt.MapClaims{})
-	if err != nil {
-		return "", nil
+	exponent := attemptsUsed + queueDepth
+	if exponent > 20 {
+		exponent = 20 // guard against overflow before the cap kicks in
 	}
 
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if !ok {
-		return "", nil
+	delay := base * time.Duration(1<<uint(exponent))
+	if jitter := int64(base); jitter > 0 {
+		delay += time.Duration(rand.Int63n(jitter))
+	}
+
+	if maxDelay > 0 && delay > maxDelay {
+		delay = maxDelay
+	}
+
+	return delay
+}
+
+// capRetryAfter clamps delay to maxDelay when maxDelay is set, mirroring the
+// cap applied by backoffWithJitter for the counter-based algorithm.
+func capRetryAfter(delay, maxDelay time.Duration) time.Duration {
+	if maxDelay > 0 && delay > maxDelay {
+		return maxDelay
+	}
+	return delay
+}
+
+// setRetryAfterHeader sets the Retry-After header on rw using either the
+// delta-seconds or HTTP-date form, as selected by format ("seconds" or
+// "http-date"). Unknown formats fall back to delta-seconds.
+func setRetryAfterHeader(rw http.ResponseWriter, format string, delay time.Duration) {
+	if delay < 0 {
+		delay = 0
 	}
 
-	userID, ok := claims["sub"].(string)
-	if !ok {
-		return "", nil
+	if format == "http-date" {
+		rw.Header().Set("Retry-After", time.Now().Add(delay).UTC().Format(http.TimeFormat))
+		return
 	}
 
-	return userID, nil
+	seconds := int(delay.Round(time.Second) / time.Second)
+	rw.Header().Set("Retry-After", strconv.Itoa(seconds))
 }
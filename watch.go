@@ -0,0 +1,155 @@
+package traefik_throttle
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// startConfigWatcher watches globalConfig.EndpointsConfigLocation for
+// changes and reloads it in the background, so editing the YAML file no
+// longer requires restarting the middleware. The watcher runs until ctx is
+// done.
+func (t *Throttle) startConfigWatcher(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("create fsnotify watcher: %v", err)
+	}
+
+	// Watch the containing directory rather than the file itself: editors
+	// commonly replace a file (write-rename) rather than write in place,
+	// which would otherwise orphan a watch on the old inode.
+	dir := filepath.Dir(t.globalConfig.EndpointsConfigLocation)
+	if err := watcher.Add(dir); err != nil {
+		watcher.Close()
+		return fmt.Errorf("watch endpoints config directory %q: %v", dir, err)
+	}
+
+	go t.watchConfigLoop(ctx, watcher)
+	return nil
+}
+
+// watchConfigLoop debounces fsnotify events for the configured file and
+// triggers a reload once they settle for ReloadDebounce.
+func (t *Throttle) watchConfigLoop(ctx context.Context, watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+
+	target := filepath.Clean(t.globalConfig.EndpointsConfigLocation)
+
+	var debounce *time.Timer
+	defer func() {
+		if debounce != nil {
+			debounce.Stop()
+		}
+	}()
+	reload := make(chan struct{}, 1)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != target {
+				continue
+			}
+			if event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+				continue
+			}
+
+			if debounce == nil {
+				debounce = time.AfterFunc(t.globalConfig.reloadDebounceDuration, func() {
+					select {
+					case reload <- struct{}{}:
+					default:
+					}
+				})
+			} else {
+				debounce.Reset(t.globalConfig.reloadDebounceDuration)
+			}
+
+		case <-reload:
+			t.reloadEndpointsConfig()
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			log(LogLevelWarning, "endpoints config watcher error", err)
+		}
+	}
+}
+
+// reloadEndpointsConfig re-reads globalConfig.EndpointsConfigLocation,
+// rebuilds the endpoint limits against it, and atomically swaps them in.
+// Endpoints that still exist keep their in-flight counts (rescaled to the
+// new limits); endpoints removed from the file are simply absent from the
+// new state and drain naturally as their in-flight requests complete.
+func (t *Throttle) reloadEndpointsConfig() {
+	location := t.globalConfig.EndpointsConfigLocation
+
+	reloaded := &Config{EndpointsConfigLocation: location}
+	if err := loadConfigFromFile(reloaded); err != nil {
+		log(LogLevelWarning, "failed to reload endpoints config from file", err)
+		return
+	}
+	reloaded.EndpointsConfigLocation = location
+
+	oldState := t.currentState()
+	newLimits := buildEndpointLimits(t.globalConfig, reloaded.Endpoints, oldState.limits)
+
+	added, removed, changed := diffEndpoints(oldState.endpointConfigs, reloaded.Endpoints)
+
+	t.state.Store(&throttleState{
+		endpointConfigs: reloaded.Endpoints,
+		limits:          newLimits,
+	})
+
+	log(LogLevelDebug, fmt.Sprintf("reloaded endpoints config from %s: +%d -%d ~%d", location, len(added), len(removed), len(changed)), nil)
+}
+
+// diffEndpoints reports which "path#method" endpoint keys were added,
+// removed, or changed between an old and new Endpoints config, for the
+// reload log line.
+func diffEndpoints(oldEndpoints, newEndpoints map[string]map[string]*Config) (added, removed, changed []string) {
+	oldKeys := endpointKeys(oldEndpoints)
+	newKeys := endpointKeys(newEndpoints)
+
+	for key, newConfig := range newKeys {
+		oldConfig, existed := oldKeys[key]
+		if !existed {
+			added = append(added, key)
+			continue
+		}
+		if oldConfig.MaxRequests != newConfig.MaxRequests ||
+			oldConfig.MaxQueue != newConfig.MaxQueue ||
+			oldConfig.RetryCount != newConfig.RetryCount ||
+			oldConfig.RetryDelay != newConfig.RetryDelay ||
+			oldConfig.Algorithm != newConfig.Algorithm {
+			changed = append(changed, key)
+		}
+	}
+	for key := range oldKeys {
+		if _, stillPresent := newKeys[key]; !stillPresent {
+			removed = append(removed, key)
+		}
+	}
+
+	return added, removed, changed
+}
+
+func endpointKeys(endpoints map[string]map[string]*Config) map[string]*Config {
+	keys := make(map[string]*Config)
+	for endpoint, methodConfigs := range endpoints {
+		for method, endpointConfig := range methodConfigs {
+			keys[fmt.Sprintf("%s#%s", endpoint, method)] = endpointConfig
+		}
+	}
+	return keys
+}